@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/moleculer-go/moleculer"
+	"github.com/moleculer-go/moleculer/broker"
+	"github.com/moleculer-go/moleculer/transit/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ = Describe("withBasicAuth", func() {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	It("Should return the handler unchanged when no credentials are configured", func() {
+		handler := withBasicAuth(ok, map[string]interface{}{"username": "", "passwordHash": ""})
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+	})
+
+	It("Should reject requests with no or wrong credentials", func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.DefaultCost)
+		Expect(err).ShouldNot(HaveOccurred())
+		handler := withBasicAuth(ok, map[string]interface{}{"username": "prom", "passwordHash": string(hash)})
+
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).Should(Equal(http.StatusUnauthorized))
+
+		req = httptest.NewRequest("GET", "/metrics", nil)
+		req.SetBasicAuth("prom", "wrong")
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("Should accept requests with the right credentials", func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.DefaultCost)
+		Expect(err).ShouldNot(HaveOccurred())
+		handler := withBasicAuth(ok, map[string]interface{}{"username": "prom", "passwordHash": string(hash)})
+
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.SetBasicAuth("prom", "s3cret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).Should(Equal(http.StatusOK))
+	})
+})
+
+var _ = Describe("buildTLSConfig", func() {
+	It("Should return a nil config with no error when certFile is not configured", func() {
+		tlsConfig, err := buildTLSConfig(map[string]interface{}{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tlsConfig).Should(BeNil())
+	})
+
+	It("Should return an error when certFile/keyFile cannot be loaded", func() {
+		_, err := buildTLSConfig(map[string]interface{}{"certFile": "/no/such/cert.pem", "keyFile": "/no/such/key.pem"})
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Should build a usable config and apply clientAuth from a valid cert/key pair", func() {
+		certFile, keyFile := writeSelfSignedCert()
+		defer os.Remove(certFile)
+		defer os.Remove(keyFile)
+
+		tlsConfig, err := buildTLSConfig(map[string]interface{}{
+			"certFile":   certFile,
+			"keyFile":    keyFile,
+			"clientAuth": tls.RequireAndVerifyClientCert,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tlsConfig.Certificates).Should(HaveLen(1))
+		Expect(tlsConfig.ClientAuth).Should(Equal(tls.RequireAndVerifyClientCert))
+	})
+})
+
+var _ = Describe("Prometheus TLS and Basic Auth scrape endpoint", func() {
+	It("Should serve /metrics over TLS and reject requests without the configured Basic Auth credentials", func() {
+		certFile, keyFile := writeSelfSignedCert()
+		defer os.Remove(certFile)
+		defer os.Remove(keyFile)
+
+		hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.DefaultCost)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		bkr := broker.New(&moleculer.Config{
+			Metrics:        true,
+			LogLevel:       "fatal",
+			DiscoverNodeID: func() string { return "TLS_Broker" },
+			TransporterFactory: func() interface{} {
+				transport := memory.Create(log.WithField("transport", "memory"), &memory.SharedMemory{})
+				return &transport
+			},
+		})
+		schema := PrometheusService()
+		schema.Settings["port"] = 3033
+		schema.Settings["tls"] = map[string]interface{}{
+			"certFile":     certFile,
+			"keyFile":      keyFile,
+			"clientCAFile": "",
+			"clientAuth":   tls.NoClientCert,
+		}
+		schema.Settings["basicAuth"] = map[string]interface{}{
+			"username":     "prom",
+			"passwordHash": string(hash),
+		}
+		bkr.Publish(schema)
+		bkr.Start()
+		defer bkr.Stop()
+		time.Sleep(300 * time.Millisecond)
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}}
+
+		req, _ := http.NewRequest("GET", "https://localhost:3033/metrics", nil)
+		resp, err := client.Do(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).Should(Equal(http.StatusUnauthorized))
+
+		req, _ = http.NewRequest("GET", "https://localhost:3033/metrics", nil)
+		req.SetBasicAuth("prom", "s3cret")
+		resp, err = client.Do(req)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(body)).Should(ContainSubstring("moleculer_nodes_total"))
+	})
+})
+
+// writeSelfSignedCert generates a throwaway self-signed cert/key pair for TLS tests
+// and returns the paths of the PEM files it wrote, for the caller to remove.
+func writeSelfSignedCert() (certFile, keyFile string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	certOut, err := ioutil.TempFile("", "cert-*.pem")
+	Expect(err).ShouldNot(HaveOccurred())
+	defer certOut.Close()
+	Expect(pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})).Should(Succeed())
+
+	keyOut, err := ioutil.TempFile("", "key-*.pem")
+	Expect(err).ShouldNot(HaveOccurred())
+	defer keyOut.Close()
+	Expect(pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})).Should(Succeed())
+
+	return certOut.Name(), keyOut.Name()
+}
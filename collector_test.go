@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("collectorFromType", func() {
+	logger := log.NewEntry(log.New())
+
+	It("Should create a SummaryVec when labelNames are given", func() {
+		collector := collectorFromType("test_summary_vec", map[string]interface{}{
+			"type":       "Summary",
+			"help":       "a test summary",
+			"labelNames": []string{"action"},
+		}, logger)
+		_, ok := collector.(*prometheus.SummaryVec)
+		Expect(ok).Should(BeTrue())
+	})
+
+	It("Should create a plain Summary when no labelNames are given", func() {
+		collector := collectorFromType("test_summary", map[string]interface{}{
+			"type": "Summary",
+			"help": "a test summary",
+		}, logger)
+		_, ok := collector.(prometheus.Summary)
+		Expect(ok).Should(BeTrue())
+	})
+
+	It("Should apply buckets to moleculer_req_duration_ms so endpoint and aggregate histograms share the same scale", func() {
+		schema := PrometheusService()
+		metrics := schema.Settings["metrics"].(map[string]interface{})
+		params := metrics["moleculer_req_duration_ms"].(map[string]interface{})
+		Expect(params["buckets"]).Should(Equal(metrics["moleculer_all_req_duration_ms"].(map[string]interface{})["buckets"]))
+	})
+})
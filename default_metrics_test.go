@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/moleculer-go/moleculer"
+	"github.com/moleculer-go/moleculer/broker"
+	"github.com/moleculer-go/moleculer/transit/memory"
+	"github.com/moleculer-go/moleculer/version"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func fetchResultsFrom(port string) string {
+	response, err := http.Get("http://localhost:" + port + "/metrics")
+	Expect(err).ShouldNot(HaveOccurred())
+	defer response.Body.Close()
+	bytes, err := ioutil.ReadAll(response.Body)
+	Expect(err).ShouldNot(HaveOccurred())
+	return string(bytes)
+}
+
+var _ = Describe("collectDefaultMetrics", func() {
+	startOn := func(port int, collectDefault bool, nodeID string) *broker.ServiceBroker {
+		bkr := broker.New(&moleculer.Config{
+			Metrics:        true,
+			LogLevel:       "fatal",
+			DiscoverNodeID: func() string { return nodeID },
+			TransporterFactory: func() interface{} {
+				transport := memory.Create(log.WithField("transport", "memory"), &memory.SharedMemory{})
+				return &transport
+			},
+		})
+		schema := PrometheusService()
+		schema.Settings["port"] = port
+		schema.Settings["collectDefaultMetrics"] = collectDefault
+		bkr.Publish(schema)
+		bkr.Start()
+		time.Sleep(300 * time.Millisecond)
+		return bkr
+	}
+
+	It("Should register the Go runtime/process collectors and moleculer_build_info when collectDefaultMetrics is true", func() {
+		bkr := startOn(3031, true, "DefaultMetrics_On")
+		defer bkr.Stop()
+
+		results := fetchResultsFrom("3031")
+		Expect(results).Should(ContainSubstring("go_goroutines"))
+		Expect(results).Should(ContainSubstring("process_start_time_seconds"))
+		Expect(getValue(results, `moleculer_build_info{goVersion="`+runtime.Version()+`",nodeID="DefaultMetrics_On",version="`+version.Moleculer()+`"}`)).Should(Equal("1"))
+	})
+
+	It("Should not register the Go runtime/process collectors or moleculer_build_info when collectDefaultMetrics is false", func() {
+		bkr := startOn(3032, false, "DefaultMetrics_Off")
+		defer bkr.Stop()
+
+		results := fetchResultsFrom("3032")
+		Expect(results).ShouldNot(ContainSubstring("go_goroutines"))
+		Expect(results).ShouldNot(ContainSubstring("process_start_time_seconds"))
+		Expect(results).ShouldNot(ContainSubstring("moleculer_build_info"))
+	})
+})
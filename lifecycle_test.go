@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"net"
+	"time"
+
+	"github.com/moleculer-go/moleculer"
+	"github.com/moleculer-go/moleculer/broker"
+	"github.com/moleculer-go/moleculer/transit/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+func startPrometheusBroker(port int, nodeID string) *broker.ServiceBroker {
+	bkr := broker.New(&moleculer.Config{
+		Metrics:        true,
+		LogLevel:       "fatal",
+		DiscoverNodeID: func() string { return nodeID },
+		TransporterFactory: func() interface{} {
+			transport := memory.Create(log.WithField("transport", "memory"), &memory.SharedMemory{})
+			return &transport
+		},
+	})
+	schema := PrometheusService()
+	schema.Settings["port"] = port
+	bkr.Publish(schema)
+	bkr.Start()
+	time.Sleep(300 * time.Millisecond)
+	return bkr
+}
+
+var _ = Describe("Prometheus private registry and graceful shutdown", func() {
+	It("Should let two independent instances run side by side without a duplicate registration panic", func() {
+		Expect(func() {
+			bkr1 := startPrometheusBroker(3034, "Lifecycle_Broker_1")
+			defer bkr1.Stop()
+			bkr2 := startPrometheusBroker(3035, "Lifecycle_Broker_2")
+			defer bkr2.Stop()
+
+			Expect(fetchResultsFrom("3034")).Should(ContainSubstring("moleculer_nodes_total"))
+			Expect(fetchResultsFrom("3035")).Should(ContainSubstring("moleculer_nodes_total"))
+		}).ShouldNot(Panic())
+	})
+
+	It("Should gracefully shut down the scrape server, freeing the port, when the service stops", func() {
+		bkr := startPrometheusBroker(3036, "Lifecycle_Broker_3")
+		bkr.Stop()
+		time.Sleep(200 * time.Millisecond)
+
+		listener, err := net.Listen("tcp", ":3036")
+		Expect(err).ShouldNot(HaveOccurred())
+		listener.Close()
+	})
+})
@@ -1,15 +1,26 @@
 package metrics
 
 import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"runtime"
 	"sync"
+	"time"
 
 	"github.com/moleculer-go/moleculer"
 	"github.com/moleculer-go/moleculer/payload"
+	"github.com/moleculer-go/moleculer/version"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // PrometheusService Moleculer metrics module for Prometheus.
@@ -34,8 +45,20 @@ import (
 func PrometheusService() moleculer.ServiceSchema {
 
 	collectors := make(map[string]prometheus.Collector)
+	reg := prometheus.NewRegistry()
 	metricsCreatedChan := make(chan bool)
 	metricsCreated := false
+
+	// typed handles to the hot-path collectors, captured once createMetrics() finishes
+	// so traceSpanFinished never looks up or type-asserts a collector from the collectors map per call.
+	var reqAllCount prometheus.Counter
+	var reqCountVec *prometheus.CounterVec
+	var reqAllDuration prometheus.Histogram
+	var reqDurationVec *prometheus.HistogramVec
+	var errorAllCount prometheus.Counter
+	var errorCountVec *prometheus.CounterVec
+	endpointCache := sync.Map{} // endpointKey -> *endpointCollectors
+
 	// createMetrics create prometheus collectors for each of the metrics setup in the settings.
 	createMetrics := func(settings map[string]interface{}, logger *log.Entry) {
 		_, exists := settings["metrics"]
@@ -55,12 +78,80 @@ func PrometheusService() moleculer.ServiceSchema {
 			params := values.(map[string]interface{})
 			collector := collectorFromType(metricName, params, logger)
 			collectors[metricName] = collector
-			prometheus.MustRegister(collector)
+			reg.MustRegister(collector)
+		}
+
+		if collectDefault, _ := settings["collectDefaultMetrics"].(bool); collectDefault {
+			registerDefaultMetrics(reg, logger)
 		}
+
+		reqAllCount = collectors["moleculer_all_req_total"].(prometheus.Counter)
+		reqCountVec = collectors["moleculer_req_total"].(*prometheus.CounterVec)
+		reqAllDuration = collectors["moleculer_all_req_duration_ms"].(prometheus.Histogram)
+		reqDurationVec = collectors["moleculer_req_duration_ms"].(*prometheus.HistogramVec)
+		errorAllCount = collectors["moleculer_all_req_errors_total"].(prometheus.Counter)
+		errorCountVec = collectors["moleculer_req_errors_total"].(*prometheus.CounterVec)
+
 		metricsCreated = true
 		metricsCreatedChan <- metricsCreated
 	}
 
+	var server *http.Server
+
+	var pusher *push.Pusher
+	stopPushing := make(chan bool)
+	// startPushing builds a Pusher from the service settings and pushes the
+	// service registry (reg) to the configured Pushgateway on every pushInterval tick.
+	// Used by short-lived/worker-mode brokers that can't be scraped directly.
+	startPushing := func(settings map[string]interface{}, logger *log.Entry) {
+		gatewayURL, _ := settings["pushgateway"].(string)
+		jobName, _ := settings["jobName"].(string)
+		if jobName == "" {
+			jobName = "moleculer"
+		}
+
+		pusher = push.New(gatewayURL, jobName).Gatherer(reg)
+		if grouping, ok := settings["grouping"].(map[string]string); ok {
+			for label, value := range grouping {
+				pusher = pusher.Grouping(label, value)
+			}
+		}
+
+		interval := 10 * time.Second
+		if ms, ok := settings["pushInterval"].(int); ok {
+			interval = time.Duration(ms) * time.Millisecond
+		}
+
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := pusher.Add(); err != nil {
+						logger.Error("prometheus.startPushing() - failed to push metrics to the pushgateway: ", err)
+					}
+				case <-stopPushing:
+					return
+				}
+			}
+		}()
+	}
+
+	// stopPushingHandler performs a final push to the Pushgateway and, when
+	// pushDeleteOnStop is set, deletes the job's metrics group afterwards.
+	stopPushingHandler := func(settings map[string]interface{}, logger *log.Entry) {
+		stopPushing <- true
+		if err := pusher.Push(); err != nil {
+			logger.Error("prometheus.stopPushingHandler() - final push to the pushgateway failed: ", err)
+		}
+		if deleteOnStop, _ := settings["pushDeleteOnStop"].(bool); deleteOnStop {
+			if err := pusher.Delete(); err != nil {
+				logger.Error("prometheus.stopPushingHandler() - failed to delete the metrics group from the pushgateway: ", err)
+			}
+		}
+	}
+
 	traceSpanFinished := func(context moleculer.Context, payload moleculer.Payload) {
 		service := payload.Get("service").Get("name").String()
 		action := payload.Get("action").Get("name").String()
@@ -69,39 +160,37 @@ func PrometheusService() moleculer.ServiceSchema {
 
 		context.Logger().Debug("prometheus.traceSpanFinished() ... ")
 
-		reqAllCount := collectors["moleculer_all_req_total"].(prometheus.Counter)
-		reqAllCount.Inc()
-
-		reqCount := collectors["moleculer_req_total"].(*prometheus.CounterVec)
-		reqCount.With(prometheus.Labels{
-			"action":  action,
-			"service": service,
-			"nodeID":  nodeID,
-		}).Inc()
+		key := endpointKey{action: action, service: service, nodeID: nodeID}
+		cached, ok := endpointCache.Load(key)
+		if !ok {
+			cached, _ = endpointCache.LoadOrStore(key, &endpointCollectors{
+				reqCount:    reqCountVec.WithLabelValues(action, service, nodeID),
+				reqDuration: reqDurationVec.WithLabelValues(action, service, nodeID),
+				errorCount:  errorCountVec.WithLabelValues(action, service, nodeID),
+			})
+		}
+		endpoint := cached.(*endpointCollectors)
 
-		reqAllDuration := collectors["moleculer_all_req_duration_ms"].(prometheus.Histogram)
-		reqAllDuration.Observe(duration)
+		reqAllCount.Inc()
+		endpoint.reqCount.Inc()
 
-		reqDuration := collectors["moleculer_req_duration_ms"].(*prometheus.HistogramVec)
-		reqDuration.With(prometheus.Labels{
-			"action":  action,
-			"service": service,
-			"nodeID":  nodeID,
-		}).Observe(duration)
+		exemplar := prometheus.Labels{}
+		if v := payload.Get("id"); v.Exists() {
+			exemplar["id"] = v.String()
+		}
+		if v := payload.Get("parentID"); v.Exists() {
+			exemplar["parentID"] = v.String()
+		}
+		if v := payload.Get("requestID"); v.Exists() {
+			exemplar["requestID"] = v.String()
+		}
+		observeWithExemplar(reqAllDuration, duration, exemplar)
+		observeWithExemplar(endpoint.reqDuration, duration, exemplar)
 
 		if payload.Get("error").Exists() {
-			errorAllCount := collectors["moleculer_all_req_errors_total"].(prometheus.Counter)
 			errorAllCount.Inc()
-
-			errorCount := collectors["moleculer_req_errors_total"].(*prometheus.CounterVec)
-			errorCount.With(prometheus.Labels{
-				"action":       action,
-				"service":      service,
-				"nodeID":       nodeID,
-				"errorMessage": payload.Get("error").Get("message").String(),
-			}).Inc()
+			endpoint.errorCount.Inc()
 		}
-
 	}
 
 	updateMutex := sync.Mutex{}
@@ -224,6 +313,22 @@ func PrometheusService() moleculer.ServiceSchema {
 			"endpoint":              "/metrics",
 			"collectDefaultMetrics": true,
 			"timeout":               10 * 1000,
+			"mode":                  "pull",
+			"pushgateway":           "http://localhost:9091",
+			"pushInterval":          10 * 1000,
+			"jobName":               "moleculer",
+			"grouping":              map[string]string{},
+			"pushDeleteOnStop":      false,
+			"tls": map[string]interface{}{
+				"certFile":     "",
+				"keyFile":      "",
+				"clientCAFile": "",
+				"clientAuth":   tls.NoClientCert,
+			},
+			"basicAuth": map[string]interface{}{
+				"username":     "",
+				"passwordHash": "",
+			},
 			"metrics": map[string]interface{}{
 				"moleculer_nodes_total": map[string]interface{}{
 					"type": "Gauge",
@@ -276,7 +381,7 @@ func PrometheusService() moleculer.ServiceSchema {
 				},
 				"moleculer_req_errors_total": map[string]interface{}{
 					"type":       "Counter",
-					"labelNames": []string{"action", "service", "nodeID", "errorMessage"},
+					"labelNames": []string{"action", "service", "nodeID"},
 					"help":       "Moleculer request error count",
 				},
 				"moleculer_all_req_duration_ms": map[string]interface{}{
@@ -288,6 +393,7 @@ func PrometheusService() moleculer.ServiceSchema {
 					"type":       "Histogram",
 					"labelNames": []string{"action", "service", "nodeID"},
 					"help":       "Moleculer request durations",
+					"buckets":    []float64{0.001, 0.01, 0.1, 0.5, 1.0, 10.0, 100.0, 300.0, 500.0, 700.0, 1000.0, 5000.0, 10000.0},
 				},
 			},
 		},
@@ -314,18 +420,174 @@ func PrometheusService() moleculer.ServiceSchema {
 				Handler: traceSpanFinished,
 			},
 		},
-		Started: func(context moleculer.BrokerContext, service moleculer.ServiceSchema) {
-			createMetrics(service.Settings, context.Logger())
+		Started: func(brokerCtx moleculer.BrokerContext, service moleculer.ServiceSchema) {
+			createMetrics(service.Settings, brokerCtx.Logger())
+
+			if service.Settings["mode"] == "push" {
+				brokerCtx.Logger().Debug("Prometheus collector service started! mode: push -> pushgateway: ", service.Settings["pushgateway"])
+				startPushing(service.Settings, brokerCtx.Logger())
+				return
+			}
 
+			logger := brokerCtx.Logger()
 			port := fmt.Sprint(":", service.Settings["port"])
-			context.Logger().Debug("Prometheus collector service started! port: ", port)
 			endpoint := service.Settings["endpoint"].(string)
-			http.Handle(endpoint, promhttp.Handler())
-			context.Logger().Fatal(http.ListenAndServe(port, nil))
+
+			var handler http.Handler = promhttp.HandlerFor(reg, promhttp.HandlerOpts{
+				ErrorLog:          logger,
+				ErrorHandling:     promhttp.ContinueOnError,
+				Registry:          reg,
+				EnableOpenMetrics: true,
+			})
+			if basicAuth, ok := service.Settings["basicAuth"].(map[string]interface{}); ok {
+				handler = withBasicAuth(handler, basicAuth)
+			}
+
+			mux := http.NewServeMux()
+			mux.Handle(endpoint, handler)
+			server = &http.Server{Addr: port, Handler: mux}
+
+			if tlsSettings, ok := service.Settings["tls"].(map[string]interface{}); ok {
+				tlsConfig, err := buildTLSConfig(tlsSettings)
+				if err != nil {
+					logger.Error("Prometheus collector service -> failed to build TLS config, not starting the scrape endpoint: ", err)
+					return
+				}
+				server.TLSConfig = tlsConfig
+			}
+
+			logger.Debug("Prometheus collector service started! port: ", port)
+			go func() {
+				var err error
+				if server.TLSConfig != nil {
+					err = server.ListenAndServeTLS("", "")
+				} else {
+					err = server.ListenAndServe()
+				}
+				if err != nil && err != http.ErrServerClosed {
+					logger.Error("Prometheus collector service -> ListenAndServe() error: ", err)
+				}
+			}()
+		},
+		Stopped: func(brokerCtx moleculer.BrokerContext, service moleculer.ServiceSchema) {
+			if service.Settings["mode"] == "push" {
+				stopPushingHandler(service.Settings, brokerCtx.Logger())
+				return
+			}
+			if server == nil {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := server.Shutdown(ctx); err != nil {
+				brokerCtx.Logger().Error("Prometheus collector service -> server.Shutdown() error: ", err)
+			}
 		},
 	}
 }
 
+// withBasicAuth wraps handler with HTTP Basic Auth, checked in constant time against
+// the configured username and bcrypt password hash. If the username or the password
+// hash is empty it returns handler unchanged.
+func withBasicAuth(handler http.Handler, settings map[string]interface{}) http.Handler {
+	username, _ := settings["username"].(string)
+	passwordHash, _ := settings["passwordHash"].(string)
+	if username == "" || passwordHash == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		validUser := ok && subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+		validPass := ok && bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(pass)) == nil
+		if !validUser || !validPass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="prometheus"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// buildTLSConfig builds a *tls.Config for the scrape endpoint from the service's tls
+// settings. Returns a nil config, with no error, when no certFile is configured.
+func buildTLSConfig(settings map[string]interface{}) (*tls.Config, error) {
+	certFile, _ := settings["certFile"].(string)
+	keyFile, _ := settings["keyFile"].(string)
+	if certFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if clientCAFile, _ := settings["clientCAFile"].(string); clientCAFile != "" {
+		caCert, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		caPool := x509.NewCertPool()
+		caPool.AppendCertsFromPEM(caCert)
+		tlsConfig.ClientCAs = caPool
+	}
+
+	if clientAuth, ok := settings["clientAuth"].(tls.ClientAuthType); ok {
+		tlsConfig.ClientAuth = clientAuth
+	}
+
+	return tlsConfig, nil
+}
+
+// registerDefaultMetrics registers the Go runtime, build-info and process collectors
+// (collectDefaultMetrics setting) plus a moleculer_build_info gauge so operators
+// can correlate rollouts with metric changes.
+func registerDefaultMetrics(reg *prometheus.Registry, logger *log.Entry) {
+	reg.MustRegister(collectors.NewGoCollector())
+	reg.MustRegister(collectors.NewBuildInfoCollector())
+	reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	nodeID, _ := logger.Data["broker"].(string)
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "moleculer_build_info",
+		Help: "Moleculer build info",
+		ConstLabels: prometheus.Labels{
+			"version":   version.Moleculer(),
+			"nodeID":    nodeID,
+			"goVersion": runtime.Version(),
+		},
+	})
+	buildInfo.Set(1)
+	reg.MustRegister(buildInfo)
+}
+
+// endpointKey identifies the (action, service, nodeID) tuple a finished span belongs to.
+type endpointKey struct {
+	action, service, nodeID string
+}
+
+// endpointCollectors holds the label-bound collectors for one endpointKey so that
+// traceSpanFinished skips label hashing on every call after the first for that endpoint.
+type endpointCollectors struct {
+	reqCount    prometheus.Counter
+	reqDuration prometheus.Observer
+	errorCount  prometheus.Counter
+}
+
+// observeWithExemplar observes duration on observer, attaching exemplar as an
+// OpenMetrics exemplar when the underlying collector supports it (Histograms do,
+// Summaries don't) so operators can jump from a bucket straight to the request's
+// context id/requestID in their logs or tracer of choice.
+func observeWithExemplar(observer prometheus.Observer, duration float64, exemplar prometheus.Labels) {
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok {
+		exemplarObserver.ObserveWithExemplar(duration, exemplar)
+		return
+	}
+	observer.Observe(duration)
+}
+
 // collectorFromType create a prometheus collector for the metric params.
 func collectorFromType(metricName string, params map[string]interface{}, logger *log.Entry) prometheus.Collector {
 	metricType := params["type"].(string)
@@ -367,6 +629,28 @@ func collectorFromType(metricName string, params map[string]interface{}, logger
 			return prometheus.NewHistogramVec(opts, labelNames)
 		}
 		return prometheus.NewHistogram(opts)
+
+	case "Summary":
+		opts := prometheus.SummaryOpts{
+			Name: metricName,
+			Help: help,
+		}
+		if objectives, ok := params["objectives"].(map[float64]float64); ok {
+			opts.Objectives = objectives
+		}
+		if maxAge, ok := params["maxAge"].(time.Duration); ok {
+			opts.MaxAge = maxAge
+		}
+		if ageBuckets, ok := params["ageBuckets"].(uint32); ok {
+			opts.AgeBuckets = ageBuckets
+		}
+		if bufCap, ok := params["bufCap"].(uint32); ok {
+			opts.BufCap = bufCap
+		}
+		if hasLabels {
+			return prometheus.NewSummaryVec(opts, labelNames)
+		}
+		return prometheus.NewSummary(opts)
 	}
 	return nil
 }
@@ -33,6 +33,21 @@ func getValue(text, name string) string {
 	return "not found"
 }
 
+func countMetricLines(text, prefix string) int {
+	count := 0
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Index(line, "# HELP") == 0 || strings.Index(line, "# TYPE") == 0 {
+			continue
+		}
+		if strings.Index(line, prefix) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
 func fetchResults() string {
 	response, err := http.Get("http://localhost:3030/metrics")
 	Expect(err).ShouldNot(HaveOccurred())
@@ -151,6 +166,17 @@ var _ = Describe("Prometheus", func() {
 		Expect(getValue(results, "moleculer_all_req_errors_total")).Should(Equal("2"))
 	})
 
+	It("Should reuse the cached per-endpoint collectors and not label errors with errorMessage", func() {
+		results := fetchResults()
+		Expect(results).ShouldNot(ContainSubstring("errorMessage"))
+		// music.start and music.end each have their own cached label-bound counter, but so
+		// does every $node.* action updateCommonValues polls via MCall, so the vec carries
+		// one series per endpoint actually invoked, not just the two music actions.
+		Expect(countMetricLines(results, "moleculer_req_errors_total{")).Should(Equal(6))
+		Expect(getValue(results, `moleculer_req_errors_total{action="music.start",nodeID="Client_Broker_1",service="music"}`)).Should(Equal("1"))
+		Expect(getValue(results, `moleculer_req_errors_total{action="music.end",nodeID="Client_Broker_1",service="music"}`)).Should(Equal("1"))
+	})
+
 	It("Should have updated the metrics after a new services was removed", func() {
 		bkr2.Stop()
 		time.Sleep(200 * time.Millisecond)
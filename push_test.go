@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/moleculer-go/moleculer"
+	"github.com/moleculer-go/moleculer/broker"
+	"github.com/moleculer-go/moleculer/transit/memory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+// fakeGateway records the HTTP methods it receives so tests can assert on the
+// push/delete lifecycle without standing up a real Pushgateway.
+type fakeGateway struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (g *fakeGateway) handler(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	g.methods = append(g.methods, r.Method)
+	g.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *fakeGateway) seen(method string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, m := range g.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+var _ = Describe("Prometheus Pushgateway mode", func() {
+	It("Should push metrics on every tick and delete the job's group on stop when pushDeleteOnStop is set", func() {
+		gateway := &fakeGateway{}
+		server := httptest.NewServer(http.HandlerFunc(gateway.handler))
+		defer server.Close()
+
+		bkr := broker.New(&moleculer.Config{
+			Metrics:        true,
+			LogLevel:       "fatal",
+			DiscoverNodeID: func() string { return "Push_Broker" },
+			TransporterFactory: func() interface{} {
+				transport := memory.Create(log.WithField("transport", "memory"), &memory.SharedMemory{})
+				return &transport
+			},
+		})
+		schema := PrometheusService()
+		schema.Settings["mode"] = "push"
+		schema.Settings["pushgateway"] = server.URL
+		schema.Settings["pushInterval"] = 50
+		schema.Settings["pushDeleteOnStop"] = true
+		bkr.Publish(schema)
+		bkr.Start()
+
+		Eventually(func() bool { return gateway.seen(http.MethodPost) }, 2*time.Second, 20*time.Millisecond).Should(BeTrue())
+
+		bkr.Stop()
+
+		Eventually(func() bool { return gateway.seen(http.MethodPut) }, 2*time.Second, 20*time.Millisecond).Should(BeTrue())
+		Eventually(func() bool { return gateway.seen(http.MethodDelete) }, 2*time.Second, 20*time.Millisecond).Should(BeTrue())
+	})
+})